@@ -36,19 +36,84 @@ const (
 // Encoder provides methods to write out JSON constructs and values. The user is
 // responsible for producing valid sequences of JSON constructs and values.
 type Encoder struct {
-	indent   string
-	lastKind kind
-	indents  []byte
-	out      bytes.Buffer
-	scratch  [64]byte
+	indent        string
+	deterministic bool
+	lastKind      kind
+	indents       []byte
+	out           bytes.Buffer
+	scratch       [64]byte
 
 	ptrLevel uint
 	ptrSeen  map[interface{}]struct{}
 }
 
+// SetDeterministic configures whether the encoder omits the random
+// whitespace jitter that prepareNext otherwise inserts between values.
+// With it disabled, the same sequence of Write* calls always produces
+// byte-identical output, which callers need for use cases like signing
+// payloads or golden-file tests. It does not affect field or map-entry
+// ordering, which callers control themselves via the order they call
+// Write* in.
+//
+// A public protojson.MarshalOptions.Deterministic (or Canonical) field that
+// sets this, forces field-number ordering, and sorts map entries by key, is
+// not part of this chunk of the tree yet and remains a follow-up; this is
+// encoder-level prep only.
+func (e *Encoder) SetDeterministic(deterministic bool) {
+	e.deterministic = deterministic
+}
+
 func (e *Encoder) Reset() {
 	e.out.Reset()
 	e.lastKind = 0
+	e.indents = e.indents[:0]
+	e.ptrLevel = 0
+	e.ptrSeen = nil
+}
+
+// maxPooledBufferSize is the largest out buffer capacity an Encoder may have
+// and still be returned to encoderPool. Encoders that grew past this while
+// marshaling an unusually large message are left for the garbage collector
+// instead, so one big message can't pin a huge buffer forever.
+const maxPooledBufferSize = 64 << 10 // 64KiB
+
+// GetEncoder returns an Encoder from encoderPool, ready for use. The
+// returned Encoder has no indent configured; callers that need one should
+// call SetIndent. Callers must call PutEncoder once they are done with the
+// returned Encoder.
+//
+// The slice returned by a subsequent call to Bytes aliases e's internal
+// buffer: it is only safe to read up until e is passed to PutEncoder, since
+// a later GetEncoder call may hand e (and that same buffer) to an unrelated
+// caller, whose Write* calls will overwrite it. Callers that need the bytes
+// to outlive PutEncoder must copy them first, e.g. append(dst[:0],
+// e.Bytes()...).
+//
+// GetEncoder/PutEncoder are the pooling primitives a future
+// protojson.Marshal/MarshalOptions.Marshal would call into; that wiring, and
+// the public protojson.NewMarshaler()/Release() pair, are not part of this
+// chunk of the tree yet and remain a follow-up.
+func GetEncoder() *Encoder {
+	if e, ok := encoderPool.Get().(*Encoder); ok {
+		e.Reset()
+		e.indent = ""
+		e.deterministic = false
+		return e
+	}
+	return NewEncoder()
+}
+
+// PutEncoder returns e to encoderPool for reuse by a later GetEncoder call.
+// Encoders whose buffer has grown beyond maxPooledBufferSize are dropped
+// rather than pooled.
+//
+// Once PutEncoder(e) is called, any slice previously obtained from
+// e.Bytes() must no longer be read: see the GetEncoder doc comment.
+func PutEncoder(e *Encoder) {
+	if e.out.Cap() > maxPooledBufferSize {
+		return
+	}
+	encoderPool.Put(e)
 }
 
 func (e *Encoder) SetIndent(indent string) error {
@@ -72,6 +137,10 @@ func NewEncoder() *Encoder {
 }
 
 // Bytes returns the content of the written bytes.
+//
+// The returned slice aliases e's internal buffer. If e came from
+// GetEncoder, the slice becomes unsafe to read as soon as e is passed to
+// PutEncoder; copy it first if it needs to outlive that call.
 func (e *Encoder) Bytes() []byte {
 	return e.out.Bytes()
 }
@@ -310,8 +379,9 @@ func (e *Encoder) prepareNext(next kind) {
 			next&(name|scalar|objectOpen|arrayOpen) != 0 {
 			e.out.WriteByte(',')
 			// For single-line output, add a random extra space after each
-			// comma to make output unstable.
-			if detrand.Bool() {
+			// comma to make output unstable, unless deterministic output
+			// was requested.
+			if !e.deterministic && detrand.Bool() {
 				e.out.WriteByte(' ')
 			}
 		}
@@ -344,8 +414,8 @@ func (e *Encoder) prepareNext(next kind) {
 	case e.lastKind&name != 0:
 		e.out.WriteByte(' ')
 		// For multi-line output, add a random extra space after key: to make
-		// output unstable.
-		if detrand.Bool() {
+		// output unstable, unless deterministic output was requested.
+		if !e.deterministic && detrand.Bool() {
 			e.out.WriteByte(' ')
 		}
 	}
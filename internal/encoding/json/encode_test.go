@@ -0,0 +1,114 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestGetPutEncoderConcurrent exercises GetEncoder/PutEncoder from many
+// goroutines at once. It is meant to be run with -race: encoderPool is a
+// package-level sync.Pool, and a badly written Reset could leave state
+// (e.g. a shared indents slice) visible to a concurrent user.
+func TestGetPutEncoderConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				e := GetEncoder()
+				e.StartObject()
+				e.WriteName("g")
+				e.WriteInt(int64(g))
+				e.EndObject()
+				_ = e.Bytes()
+				PutEncoder(e)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// TestPutEncoderEvictsOversizedBuffer checks that an Encoder whose buffer
+// grew past maxPooledBufferSize is not handed back out by a later
+// GetEncoder call, so one oversized message can't pin its buffer in the
+// pool forever.
+func TestPutEncoderEvictsOversizedBuffer(t *testing.T) {
+	big := NewEncoder()
+	big.out.Grow(maxPooledBufferSize + 1)
+	big.out.WriteString(strconv.Itoa(maxPooledBufferSize + 1))
+	if big.out.Cap() <= maxPooledBufferSize {
+		t.Fatalf("test setup: out.Cap() = %d, want > %d", big.out.Cap(), maxPooledBufferSize)
+	}
+	PutEncoder(big)
+
+	// Drain whatever GetEncoder hands back; big must never be among it.
+	for i := 0; i < 8; i++ {
+		if got := GetEncoder(); got == big {
+			t.Fatalf("GetEncoder returned an encoder with an oversized buffer (cap %d > max %d)", got.out.Cap(), maxPooledBufferSize)
+		}
+	}
+}
+
+// TestSetDeterministicSuppressesJitter pins the one behavior
+// SetDeterministic changes: detrand's extra whitespace must never appear,
+// regardless of what detrand.Bool() would otherwise return in this process.
+func TestSetDeterministicSuppressesJitter(t *testing.T) {
+	e := NewEncoder()
+	e.SetDeterministic(true)
+	e.StartObject()
+	e.WriteName("a")
+	e.WriteInt(1)
+	e.WriteName("b")
+	e.WriteInt(2)
+	e.EndObject()
+
+	if got, want := string(e.Bytes()), `{"a":1,"b":2}`; got != want {
+		t.Errorf("deterministic output = %q, want %q", got, want)
+	}
+}
+
+// TestBytesAliasesBufferAcrossReuse documents and pins the GetEncoder/
+// PutEncoder footgun called out on Bytes' doc comment: the slice returned
+// by Bytes aliases e's internal buffer, so reusing e (whether via a direct
+// Reset, as here, or via PutEncoder followed by a later GetEncoder) silently
+// overwrites any slice obtained before the reuse. Callers that need the
+// bytes to survive a reuse must copy them first.
+func TestBytesAliasesBufferAcrossReuse(t *testing.T) {
+	e := NewEncoder()
+	e.WriteString("first")
+	b := e.Bytes()
+	want := string(b)
+
+	e.Reset()
+	e.WriteString("second-and-longer")
+
+	if got := string(b); got == want {
+		t.Fatalf("Bytes() result survived reuse unchanged (got %q); Bytes/GetEncoder/PutEncoder document that it must not, since the slice aliases e's buffer", got)
+	}
+}
+
+// BenchmarkGetPutEncoder_Scalars exercises the pooled path directly
+// (GetEncoder/PutEncoder), as opposed to the pre-existing protojson
+// benchmarks which exercise the reflection-based marshaler. It should show
+// zero allocations per op once the pool has warmed up.
+func BenchmarkGetPutEncoder_Scalars(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		e := GetEncoder()
+		e.StartObject()
+		e.WriteName("a")
+		e.WriteBool(true)
+		e.WriteName("b")
+		e.WriteInt(42)
+		e.WriteName("c")
+		e.WriteUint(7)
+		e.EndObject()
+		PutEncoder(e)
+	}
+}
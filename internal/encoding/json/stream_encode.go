@@ -0,0 +1,405 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/schattian/protobuf/internal/detrand"
+	"github.com/schattian/protobuf/internal/errors"
+)
+
+// StreamEncoder provides the same methods as Encoder, but writes each token
+// directly to an underlying io.Writer as it is produced instead of
+// buffering the whole output in memory. This lets callers marshal large
+// messages straight onto an HTTP response, a gRPC stream, or a file without
+// holding a second copy of the encoded bytes.
+//
+// Unlike Encoder, every Write* method returns an error: any failure from the
+// underlying writer is returned from the call during which it occurred, and
+// from every call thereafter.
+//
+// This is encoder-level plumbing only: encoding/protojson's own
+// MarshalOptions.MarshalStream entry point, which would walk a message via
+// reflection and call these Write* methods, is not wired up in this chunk
+// of the tree and remains a follow-up.
+type StreamEncoder struct {
+	indent        string
+	deterministic bool
+	lastKind      kind
+	indents       []byte
+	w             *bufio.Writer
+	scratch       [64]byte
+
+	err error
+}
+
+// SetDeterministic configures whether the encoder omits the random
+// whitespace jitter that prepareNext otherwise inserts between values. See
+// Encoder.SetDeterministic for details.
+func (e *StreamEncoder) SetDeterministic(deterministic bool) {
+	e.deterministic = deterministic
+}
+
+// NewStreamEncoder returns a StreamEncoder that writes to w, with no indent
+// configured.
+//
+// If SetIndent is later given a non-empty string, it causes every entry for
+// an Array or Object to be preceded by the indent and trailed by a newline.
+func NewStreamEncoder(w io.Writer) *StreamEncoder {
+	return &StreamEncoder{w: bufio.NewWriter(w)}
+}
+
+func (e *StreamEncoder) SetIndent(indent string) error {
+	if len(indent) == 0 {
+		e.indent = ""
+		return nil
+	}
+	if strings.Trim(indent, " \t") != "" {
+		return errors.New("indent may only be composed of space or tab characters")
+	}
+	e.indent = indent
+	return nil
+}
+
+// Flush writes any buffered bytes to the underlying io.Writer. Callers must
+// call Flush after the last Write* call to ensure all output has been
+// written out.
+func (e *StreamEncoder) Flush() error {
+	if e.err != nil {
+		return e.err
+	}
+	e.err = e.w.Flush()
+	return e.err
+}
+
+func (e *StreamEncoder) writeByte(b byte) {
+	if e.err != nil {
+		return
+	}
+	e.err = e.w.WriteByte(b)
+}
+
+func (e *StreamEncoder) writeString(s string) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.WriteString(s)
+}
+
+func (e *StreamEncoder) write(b []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
+// WriteNull writes out the null value.
+func (e *StreamEncoder) WriteNull() error {
+	e.prepareNext(scalar)
+	e.writeString("null")
+	return e.err
+}
+
+// WriteBool writes out the given boolean value.
+func (e *StreamEncoder) WriteBool(b bool) error {
+	e.prepareNext(scalar)
+	if b {
+		e.writeString("true")
+	} else {
+		e.writeString("false")
+	}
+	return e.err
+}
+
+// WriteString writes out the given string in JSON string value. Returns
+// error if input string contains invalid UTF-8 or the writer fails.
+//
+// Unlike Encoder, an invalid-UTF-8 error poisons e just like a writer
+// failure: s is validated in full before any of it is written, so the
+// invalid call itself never reaches the writer, but there is still no way to
+// undo a partially-streamed message and resume cleanly. Every later Write*
+// call, and Flush, return the same error.
+func (e *StreamEncoder) WriteString(s string) error {
+	e.prepareNext(scalar)
+	return e.appendString(s)
+}
+
+func (e *StreamEncoder) WriteByteSlice(s []byte) error {
+	e.prepareNext(scalar)
+
+	e.writeByte('"')
+	encodedLen := base64.StdEncoding.EncodedLen(len(s))
+	if encodedLen <= len(e.scratch) {
+		// If the encoded bytes fit in e.scratch, avoid an extra
+		// allocation and use the cheaper Encoding.Encode.
+		dst := e.scratch[:encodedLen]
+		base64.StdEncoding.Encode(dst, s)
+		e.write(dst)
+	} else if encodedLen <= 1024 {
+		// The encoded bytes are short enough to allocate for, and
+		// Encoding.Encode is still cheaper.
+		dst := make([]byte, encodedLen)
+		base64.StdEncoding.Encode(dst, s)
+		e.write(dst)
+	} else if e.err == nil {
+		// The encoded bytes are too long to cheaply allocate, and
+		// Encoding.Encode is no longer noticeably cheaper.
+		enc := base64.NewEncoder(base64.StdEncoding, e.w)
+		enc.Write(s)
+		e.err = enc.Close()
+	}
+	e.writeByte('"')
+	return e.err
+}
+
+// appendString writes the JSON-escaped form of in. Unlike Encoder, which
+// only ever buffers into memory, e's underlying bufio.Writer auto-flushes to
+// the real io.Writer as soon as a single writeString call exceeds its
+// available space — so for a large in, emitting the opening quote and valid
+// prefix before validating the tail could push a truncated token onto the
+// wire before the invalid rune is ever found. To avoid that, in is
+// validated in full up front, before any byte of it is written; on
+// errInvalidUTF8 nothing has been queued for this call, and that error is
+// stored in e.err like a writer failure, poisoning every later Write* call
+// and Flush.
+func (e *StreamEncoder) appendString(in string) error {
+	if e.err != nil {
+		return e.err
+	}
+	if !utf8.ValidString(in) {
+		e.err = errInvalidUTF8
+		return e.err
+	}
+	e.writeByte('"')
+	i := indexNeedEscapeInString(in)
+	e.writeString(in[:i])
+	in = in[i:]
+	for len(in) > 0 {
+		switch r, n := utf8.DecodeRuneInString(in); {
+		case r == utf8.RuneError && n == 1:
+			// Unreachable: in was fully validated above.
+			e.err = errInvalidUTF8
+			return e.err
+		case r < ' ' || r == '"' || r == '\\':
+			e.writeByte('\\')
+			switch r {
+			case '"', '\\':
+				if e.err == nil {
+					_, e.err = e.w.WriteRune(r)
+				}
+			case '\b':
+				e.writeByte('b')
+			case '\f':
+				e.writeByte('f')
+			case '\n':
+				e.writeByte('n')
+			case '\r':
+				e.writeByte('r')
+			case '\t':
+				e.writeByte('t')
+			default:
+				e.writeByte('u')
+				e.writeString("0000"[1+(bits.Len32(uint32(r))-1)/4:])
+				b := strconv.AppendUint(e.scratch[:0], uint64(r), 16)
+				e.write(b)
+			}
+			in = in[n:]
+		default:
+			i := indexNeedEscapeInString(in[n:])
+			e.writeString(in[:n+i])
+			in = in[n+i:]
+		}
+	}
+	e.writeByte('"')
+	return e.err
+}
+
+// WriteFloat writes out the given float and bitSize in JSON number value.
+func (e *StreamEncoder) WriteFloat(n float64, bitSize int) error {
+	e.prepareNext(scalar)
+	e.appendFloat(n, bitSize)
+	return e.err
+}
+
+func (e *StreamEncoder) appendFloat(n float64, bitSize int) {
+	switch {
+	case math.IsNaN(n):
+		e.writeString(`"NaN"`)
+		return
+	case math.IsInf(n, +1):
+		e.writeString(`"Infinity"`)
+		return
+	case math.IsInf(n, -1):
+		e.writeString(`"-Infinity"`)
+		return
+	}
+
+	// JSON number formatting logic based on encoding/json.
+	// See floatEncoder.encode for reference.
+	b := e.scratch[:0]
+	fmt := byte('f')
+	if abs := math.Abs(n); abs != 0 {
+		if bitSize == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			bitSize == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			fmt = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, n, fmt, -1, bitSize)
+	if fmt == 'e' {
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+
+	e.write(b)
+}
+
+// WriteInt writes out the given signed integer in JSON number value.
+func (e *StreamEncoder) WriteInt(n int64) error {
+	e.prepareNext(scalar)
+	b := strconv.AppendInt(e.scratch[:0], n, 10)
+	e.write(b)
+	return e.err
+}
+
+// WriteUint writes out the given unsigned integer in JSON number value.
+func (e *StreamEncoder) WriteUint(n uint64) error {
+	e.prepareNext(scalar)
+	b := strconv.AppendUint(e.scratch[:0], n, 10)
+	e.write(b)
+	return e.err
+}
+
+// WriteInt64 writes the given int64 as string (as it's specified by the I-JSON spec)
+func (e *StreamEncoder) WriteInt64(n int64) error {
+	e.prepareNext(scalar)
+	b := strconv.AppendInt(e.scratch[:0], n, 10)
+	e.writeByte('"')
+	e.write(b)
+	e.writeByte('"')
+	return e.err
+}
+
+// WriteUint64 writes the given uint64 as string (as it's specified by the I-JSON spec)
+func (e *StreamEncoder) WriteUint64(n uint64) error {
+	e.prepareNext(scalar)
+	b := strconv.AppendUint(e.scratch[:0], n, 10)
+	e.writeByte('"')
+	e.write(b)
+	e.writeByte('"')
+	return e.err
+}
+
+// StartObject writes out the '{' symbol.
+func (e *StreamEncoder) StartObject() error {
+	e.prepareNext(objectOpen)
+	e.writeByte('{')
+	return e.err
+}
+
+// EndObject writes out the '}' symbol.
+func (e *StreamEncoder) EndObject() error {
+	e.prepareNext(objectClose)
+	e.writeByte('}')
+	return e.err
+}
+
+// WriteName writes out the given string in JSON string value and the name
+// separator ':'. Returns error if input string contains invalid UTF-8, which
+// should not be likely as protobuf field names should be valid. Like
+// WriteString, an invalid-UTF-8 error poisons e.
+func (e *StreamEncoder) WriteName(s string) error {
+	e.prepareNext(name)
+	err := e.appendString(s)
+	e.writeByte(':')
+	if err != nil {
+		return err
+	}
+	return e.err
+}
+
+// StartArray writes out the '[' symbol.
+func (e *StreamEncoder) StartArray() error {
+	e.prepareNext(arrayOpen)
+	e.writeByte('[')
+	return e.err
+}
+
+// EndArray writes out the ']' symbol.
+func (e *StreamEncoder) EndArray() error {
+	e.prepareNext(arrayClose)
+	e.writeByte(']')
+	return e.err
+}
+
+// prepareNext adds possible comma and indentation for the next value based
+// on last type and indent option. It also updates lastKind to next.
+func (e *StreamEncoder) prepareNext(next kind) {
+	defer func() {
+		// Set lastKind to next.
+		e.lastKind = next
+	}()
+
+	if e.err != nil {
+		return
+	}
+
+	if len(e.indent) == 0 {
+		// Need to add comma on the following condition.
+		if e.lastKind&(scalar|objectClose|arrayClose) != 0 &&
+			next&(name|scalar|objectOpen|arrayOpen) != 0 {
+			e.writeByte(',')
+			// For single-line output, add a random extra space after each
+			// comma to make output unstable, unless deterministic output
+			// was requested.
+			if !e.deterministic && detrand.Bool() {
+				e.writeByte(' ')
+			}
+		}
+		return
+	}
+
+	switch {
+	case e.lastKind&(objectOpen|arrayOpen) != 0:
+		// If next type is NOT closing, add indent and newline.
+		if next&(objectClose|arrayClose) == 0 {
+			e.indents = append(e.indents, e.indent...)
+			e.writeByte('\n')
+			e.write(e.indents)
+		}
+
+	case e.lastKind&(scalar|objectClose|arrayClose) != 0:
+		switch {
+		// If next type is either a value or name, add comma and newline.
+		case next&(name|scalar|objectOpen|arrayOpen) != 0:
+			e.writeByte(',')
+			e.writeByte('\n')
+
+		// If next type is a closing object or array, adjust indentation.
+		case next&(objectClose|arrayClose) != 0:
+			e.indents = e.indents[:len(e.indents)-len(e.indent)]
+			e.writeByte('\n')
+		}
+		e.write(e.indents)
+
+	case e.lastKind&name != 0:
+		e.writeByte(' ')
+		// For multi-line output, add a random extra space after key: to make
+		// output unstable, unless deterministic output was requested.
+		if !e.deterministic && detrand.Bool() {
+			e.writeByte(' ')
+		}
+	}
+}
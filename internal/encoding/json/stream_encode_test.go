@@ -0,0 +1,210 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package json_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/schattian/protobuf/internal/encoding/json"
+)
+
+// TestStreamEncoderMatchesEncoder checks that StreamEncoder produces the
+// same bytes as the buffered Encoder for an equivalent sequence of calls,
+// which is the whole point of offering it as a drop-in streaming
+// alternative.
+func TestStreamEncoderMatchesEncoder(t *testing.T) {
+	e := json.NewEncoder()
+	e.SetDeterministic(true)
+	if err := e.SetIndent("  "); err != nil {
+		t.Fatalf("Encoder.SetIndent: %v", err)
+	}
+	e.StartObject()
+	e.WriteName("s")
+	e.WriteString("hello")
+	e.WriteName("n")
+	e.WriteInt(42)
+	e.WriteName("arr")
+	e.StartArray()
+	e.WriteBool(true)
+	e.WriteFloat(3.5, 64)
+	e.EndArray()
+	e.EndObject()
+	want := string(e.Bytes())
+
+	var buf bytes.Buffer
+	se := json.NewStreamEncoder(&buf)
+	se.SetDeterministic(true)
+	if err := se.SetIndent("  "); err != nil {
+		t.Fatalf("StreamEncoder.SetIndent: %v", err)
+	}
+	calls := []func() error{
+		se.StartObject,
+		func() error { return se.WriteName("s") },
+		func() error { return se.WriteString("hello") },
+		func() error { return se.WriteName("n") },
+		func() error { return se.WriteInt(42) },
+		func() error { return se.WriteName("arr") },
+		se.StartArray,
+		func() error { return se.WriteBool(true) },
+		func() error { return se.WriteFloat(3.5, 64) },
+		se.EndArray,
+		se.EndObject,
+	}
+	for _, call := range calls {
+		if err := call(); err != nil {
+			t.Fatalf("StreamEncoder call: %v", err)
+		}
+	}
+	if err := se.Flush(); err != nil {
+		t.Fatalf("StreamEncoder.Flush: %v", err)
+	}
+
+	if got := buf.String(); got != want {
+		t.Errorf("StreamEncoder output =\n%q\nwant (Encoder output):\n%q", got, want)
+	}
+}
+
+// failingWriter returns err from every Write call.
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
+
+// TestStreamEncoderPropagatesWriterError checks that a write failure on the
+// underlying io.Writer is surfaced through Flush, and then remains sticky on
+// every subsequent Write* call instead of silently succeeding.
+func TestStreamEncoderPropagatesWriterError(t *testing.T) {
+	wantErr := errors.New("write failed")
+	se := json.NewStreamEncoder(failingWriter{wantErr})
+
+	// Small writes may sit in the internal buffer until Flush forces them
+	// out, so the first failure is only guaranteed to surface there.
+	if err := se.WriteBool(true); err != nil {
+		t.Fatalf("WriteBool before Flush: got error %v, want nil (buffered)", err)
+	}
+	if err := se.Flush(); !errors.Is(err, wantErr) {
+		t.Fatalf("Flush: got error %v, want %v", err, wantErr)
+	}
+	if err := se.WriteBool(false); !errors.Is(err, wantErr) {
+		t.Errorf("WriteBool after failed Flush: got error %v, want sticky %v", err, wantErr)
+	}
+	if err := se.Flush(); !errors.Is(err, wantErr) {
+		t.Errorf("Flush after failed Flush: got error %v, want sticky %v", err, wantErr)
+	}
+}
+
+// TestStreamEncoderInvalidUTF8PoisonsStream checks that an invalid-UTF-8
+// string value poisons the encoder like a genuine writer failure. The string
+// is validated before any byte of it is queued, so nothing of the invalid
+// token is written; the encoder still cannot resume a partially-streamed
+// message cleanly, so every later Write* call must keep failing, and Flush
+// must not push anything out to the underlying writer.
+func TestStreamEncoderInvalidUTF8PoisonsStream(t *testing.T) {
+	var buf bytes.Buffer
+	se := json.NewStreamEncoder(&buf)
+
+	if err := se.WriteString("\xff"); err == nil {
+		t.Fatal("WriteString with invalid UTF-8: got nil error, want non-nil")
+	}
+
+	if err := se.WriteString("ok"); err == nil {
+		t.Fatal("WriteString after invalid UTF-8 value: got nil error, want sticky error")
+	}
+	if err := se.Flush(); err == nil {
+		t.Fatal("Flush after invalid UTF-8 value: got nil error, want sticky error")
+	}
+	if got := buf.String(); got != "" {
+		t.Errorf("buffered output = %q, want empty: the truncated token must never reach the underlying writer", got)
+	}
+}
+
+// TestStreamEncoderInvalidUTF8InNamePoisonsStream is the WriteName analogue:
+// an invalid object field name must poison the stream the same way an
+// invalid string value does, and must not leak the already-queued "{" to the
+// underlying writer.
+func TestStreamEncoderInvalidUTF8InNamePoisonsStream(t *testing.T) {
+	var buf bytes.Buffer
+	se := json.NewStreamEncoder(&buf)
+
+	if err := se.StartObject(); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteName("\xff"); err == nil {
+		t.Fatal("WriteName with invalid UTF-8: got nil error, want non-nil")
+	}
+	if err := se.WriteInt(1); err == nil {
+		t.Fatal("WriteInt after invalid UTF-8 name: got nil error, want sticky error")
+	}
+	if err := se.Flush(); err == nil {
+		t.Fatal("Flush after invalid UTF-8 name: got nil error, want sticky error")
+	}
+	if got := buf.String(); got != "" {
+		t.Errorf("buffered output = %q, want empty: the truncated token must never reach the underlying writer", got)
+	}
+}
+
+// TestStreamEncoderInvalidUTF8LargeValidPrefixDoesNotLeak checks the case a
+// tiny input can't exercise: a string whose valid prefix is larger than the
+// bufio.Writer's available space (default 4096 bytes), with the invalid
+// byte at the very end. A naive implementation that streams the prefix
+// before validating the tail would auto-flush that prefix straight to the
+// underlying writer the moment the write call exceeds the available buffer
+// space, long before the bad byte is ever decoded.
+func TestStreamEncoderInvalidUTF8LargeValidPrefixDoesNotLeak(t *testing.T) {
+	var buf bytes.Buffer
+	se := json.NewStreamEncoder(&buf)
+
+	s := strings.Repeat("a", 8<<10) + "\xff"
+	if err := se.WriteString(s); err == nil {
+		t.Fatal("WriteString with large valid prefix + invalid UTF-8 tail: got nil error, want non-nil")
+	}
+	if err := se.Flush(); err == nil {
+		t.Fatal("Flush after invalid UTF-8 value: got nil error, want sticky error")
+	}
+	if got := buf.String(); got != "" {
+		t.Errorf("buffered output = %q, want empty: the large valid prefix must never reach the underlying writer", got)
+	}
+}
+
+// TestStreamEncoderSetDeterministicSuppressesJitter pins the one behavior
+// SetDeterministic changes: detrand's extra whitespace must never appear,
+// regardless of what detrand.Bool() would otherwise return in this process.
+func TestStreamEncoderSetDeterministicSuppressesJitter(t *testing.T) {
+	var buf bytes.Buffer
+	se := json.NewStreamEncoder(&buf)
+	se.SetDeterministic(true)
+
+	if err := se.StartObject(); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteName("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteInt(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteName("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.WriteInt(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.EndObject(); err != nil {
+		t.Fatal(err)
+	}
+	if err := se.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), `{"a":1,"b":2}`; got != want {
+		t.Errorf("deterministic output = %q, want %q", got, want)
+	}
+}